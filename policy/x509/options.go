@@ -0,0 +1,142 @@
+package x509policy
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// NamePolicyOption is used to configure a NamePolicyEngine when it is
+// created with New.
+type NamePolicyOption func(e *NamePolicyEngine) error
+
+// defaultMaxConstraintComparisons mirrors the limit the Go standard library
+// applies in crypto/x509: the maximum number of name-vs-constraint
+// comparisons that are allowed while validating a single CSR or certificate.
+// SOURCE: https://cs.opensource.google/go/go/+/refs/tags/go1.17.5:src/crypto/x509/verify.go
+const defaultMaxConstraintComparisons = 250
+
+// WithMaxComparisons sets the maximum number of name-vs-constraint
+// comparisons that validateNames is allowed to perform before it gives up
+// and reports a CertificateInvalidError with Reason x509.TooManyConstraints.
+// This protects the CA from a CSR or certificate that, combined with a
+// policy with many constraints, would otherwise result in a quadratic
+// number of comparisons. It defaults to 250, matching the stdlib limit.
+// Setting n to 0 restores that default; pass a negative n to disable the
+// check entirely.
+func WithMaxComparisons(n int) NamePolicyOption {
+	return func(e *NamePolicyEngine) error {
+		e.maxConstraintComparisons = n
+		return nil
+	}
+}
+
+// WithPermittedCIDR parses cidr (e.g. "10.0.0.0/8" or "fd00::/8") and adds it
+// to the set of permitted IP range constraints, keeping track of whichever
+// address family the CIDR was written in. Operators can therefore list an
+// IPv4 and an IPv6 range side by side without either one being silently
+// coerced into the other's family during matching.
+func WithPermittedCIDR(cidr string) NamePolicyOption {
+	return func(e *NamePolicyEngine) error {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return errors.Wrapf(err, "error parsing permitted CIDR %q", cidr)
+		}
+		e.permittedIPRanges = append(e.permittedIPRanges, ipNet)
+		return nil
+	}
+}
+
+// WithExcludedCIDR parses cidr (e.g. "10.0.0.0/8" or "fd00::/8") and adds it
+// to the set of excluded IP range constraints, keeping track of whichever
+// address family the CIDR was written in. Operators can therefore list an
+// IPv4 and an IPv6 range side by side without either one being silently
+// coerced into the other's family during matching.
+func WithExcludedCIDR(cidr string) NamePolicyOption {
+	return func(e *NamePolicyEngine) error {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return errors.Wrapf(err, "error parsing excluded CIDR %q", cidr)
+		}
+		e.excludedIPRanges = append(e.excludedIPRanges, ipNet)
+		return nil
+	}
+}
+
+// WithPermittedDirectoryName adds name to the set of permitted directory name
+// (X.500 Subject DN) constraints. A certificate or CSR is only allowed if its
+// Subject DN is within at least one of the permitted directory names, per RFC
+// 5280, Section 4.2.1.10.
+func WithPermittedDirectoryName(name pkix.Name) NamePolicyOption {
+	return func(e *NamePolicyEngine) error {
+		e.permittedDirectoryNames = append(e.permittedDirectoryNames, name.ToRDNSequence())
+		return nil
+	}
+}
+
+// WithExcludedDirectoryName adds name to the set of excluded directory name
+// (X.500 Subject DN) constraints. A certificate or CSR whose Subject DN is
+// within any of the excluded directory names is rejected.
+func WithExcludedDirectoryName(name pkix.Name) NamePolicyOption {
+	return func(e *NamePolicyEngine) error {
+		e.excludedDirectoryNames = append(e.excludedDirectoryNames, name.ToRDNSequence())
+		return nil
+	}
+}
+
+// WithSubjectCommonNameValidation enables checking the Subject CommonName of
+// a certificate or CSR against the configured DNS and IP constraints, in
+// addition to the SANs. The CommonName is parsed as an IP address when
+// possible and matched using the IP constraints; otherwise it is matched
+// using the DNS constraints. A CommonName of "" is never checked, matching
+// the way an empty CommonName is otherwise ignored by this package.
+func WithSubjectCommonNameValidation() NamePolicyOption {
+	return func(e *NamePolicyEngine) error {
+		e.verifySubjectCommonName = true
+		return nil
+	}
+}
+
+// WithAllowEmptySANs disables the default rejection of a certificate or CSR
+// that presents zero SANs of any kind while DNS, IP, email or URI
+// constraints are configured. It exists for legacy callers that relied on
+// being able to sign such requests; new policies should leave it unset.
+func WithAllowEmptySANs() NamePolicyOption {
+	return func(e *NamePolicyEngine) error {
+		e.allowEmptySANs = true
+		return nil
+	}
+}
+
+// WithAggregateErrors switches the engine from its default fail-fast
+// behavior to collect-all mode: every DNS, IP, email, URI, otherName,
+// Subject CommonName and directoryName is checked against the configured
+// constraints, and every violation is returned together as a
+// *NamePolicyError instead of stopping at the first one. This lets a CA's
+// sign or renew flow log every offending name from a single CSR or
+// certificate in one audit line.
+func WithAggregateErrors() NamePolicyOption {
+	return func(e *NamePolicyEngine) error {
+		e.aggregateErrors = true
+		return nil
+	}
+}
+
+// WithCustomSANChecker registers checker to validate the otherName SAN
+// entries whose type-id OID matches oid, e.g. OIDUPN or OIDSRVName. Go's
+// standard library skips otherName SANs entirely; once a checker is
+// registered for an OID, validateNames parses the raw SubjectAltName
+// extension and rejects a CSR or certificate whose otherName of that type
+// isn't permitted by the checker. Registering a checker for an OID that
+// doesn't appear in a given CSR or certificate is a no-op for that request.
+func WithCustomSANChecker(oid asn1.ObjectIdentifier, checker SANChecker) NamePolicyOption {
+	return func(e *NamePolicyEngine) error {
+		if e.customSANCheckers == nil {
+			e.customSANCheckers = make(map[string]SANChecker)
+		}
+		e.customSANCheckers[oid.String()] = checker
+		return nil
+	}
+}
@@ -3,6 +3,8 @@ package x509policy
 import (
 	"bytes"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"fmt"
 	"net"
 	"net/url"
@@ -40,24 +42,79 @@ func (e CertificateInvalidError) Error() string {
 		return "issuer has name constraints but csr doesn't have a SAN extension"
 	case x509.UnconstrainedName:
 		return "issuer has name constraints but csr contains unknown or unconstrained name: " + e.Detail
+	case x509.TooManyConstraints:
+		return "too many comparisons while checking name constraints: " + e.Detail
 	}
 	return "unknown error"
 }
 
+// NamePolicyError is returned by validateNames when the NamePolicyEngine was
+// created WithAggregateErrors. Instead of failing on the first name that
+// violates a constraint, every DNS, IP, email, URI, otherName, Subject
+// CommonName and directoryName is checked, and every resulting
+// CertificateInvalidError is collected into a single NamePolicyError so that
+// a CA's sign or renew flow can log every offending name in one audit line.
+type NamePolicyError struct {
+	violations []CertificateInvalidError
+}
+
+// newNamePolicyError builds a NamePolicyError from the violations collected
+// while checking all names against the configured constraints.
+func newNamePolicyError(violations []CertificateInvalidError) *NamePolicyError {
+	return &NamePolicyError{violations: violations}
+}
+
+// Violations returns every CertificateInvalidError collected while
+// validating names, in the order the names were checked: DNS, IP, email,
+// URI, otherName, Subject CommonName, then directoryName. A
+// NameConstraintsWithoutSANs violation, if any, comes before all of those.
+func (e *NamePolicyError) Violations() []CertificateInvalidError {
+	return e.violations
+}
+
+// Error renders every collected violation, one per line, ordered the same
+// way as Violations.
+func (e *NamePolicyError) Error() string {
+	msgs := make([]string, len(e.violations))
+	for i, v := range e.violations {
+		msgs[i] = v.Error()
+	}
+	return fmt.Sprintf("%d name constraint violations:\n%s", len(e.violations), strings.Join(msgs, "\n"))
+}
+
+// Unwrap exposes the collected violations to errors.Is and errors.As, so
+// that callers can inspect an aggregated NamePolicyError the same way they
+// would a single CertificateInvalidError. errors.Is only reports a match for
+// a CertificateInvalidError equal to a collected one in both Reason and
+// Detail; to check the Reason alone, use errors.As and compare its Reason.
+func (e *NamePolicyError) Unwrap() []error {
+	errs := make([]error, len(e.violations))
+	for i, v := range e.violations {
+		errs[i] = v
+	}
+	return errs
+}
+
 // NamePolicyEngine can be used to check that a CSR or Certificate meets all allowed and
 // denied names before a CA creates and/or signs the Certificate.
-// TODO(hs): the x509 RFC also defines name checks on directory name; support that?
 // TODO(hs): implement Stringer interface: describe the contents of the NamePolicyEngine?
 type NamePolicyEngine struct {
-	options                 []NamePolicyOption
-	permittedDNSDomains     []string
-	excludedDNSDomains      []string
-	permittedIPRanges       []*net.IPNet
-	excludedIPRanges        []*net.IPNet
-	permittedEmailAddresses []string
-	excludedEmailAddresses  []string
-	permittedURIDomains     []string
-	excludedURIDomains      []string
+	options                  []NamePolicyOption
+	permittedDNSDomains      []string
+	excludedDNSDomains       []string
+	permittedIPRanges        []*net.IPNet
+	excludedIPRanges         []*net.IPNet
+	permittedEmailAddresses  []string
+	excludedEmailAddresses   []string
+	permittedURIDomains      []string
+	excludedURIDomains       []string
+	permittedDirectoryNames  []pkix.RDNSequence
+	excludedDirectoryNames   []pkix.RDNSequence
+	maxConstraintComparisons int
+	verifySubjectCommonName  bool
+	allowEmptySANs           bool
+	aggregateErrors          bool
+	customSANCheckers        map[string]SANChecker
 }
 
 // NewNamePolicyEngine creates a new NamePolicyEngine with NamePolicyOptions
@@ -71,12 +128,16 @@ func New(opts ...NamePolicyOption) (*NamePolicyEngine, error) {
 		}
 	}
 
+	if e.maxConstraintComparisons == 0 {
+		e.maxConstraintComparisons = defaultMaxConstraintComparisons
+	}
+
 	return e, nil
 }
 
 // AreCertificateNamesAllowed verifies that all SANs in a Certificate are allowed.
 func (e *NamePolicyEngine) AreCertificateNamesAllowed(cert *x509.Certificate) (bool, error) {
-	if err := e.validateNames(cert.DNSNames, cert.IPAddresses, cert.EmailAddresses, cert.URIs); err != nil {
+	if err := e.validateNames(cert.DNSNames, cert.IPAddresses, cert.EmailAddresses, cert.URIs, &cert.Subject, rawSANExtension(cert.Extensions)); err != nil {
 		return false, err
 	}
 	return true, nil
@@ -84,7 +145,7 @@ func (e *NamePolicyEngine) AreCertificateNamesAllowed(cert *x509.Certificate) (b
 
 // AreCSRNamesAllowed verifies that all names in the CSR are allowed.
 func (e *NamePolicyEngine) AreCSRNamesAllowed(csr *x509.CertificateRequest) (bool, error) {
-	if err := e.validateNames(csr.DNSNames, csr.IPAddresses, csr.EmailAddresses, csr.URIs); err != nil {
+	if err := e.validateNames(csr.DNSNames, csr.IPAddresses, csr.EmailAddresses, csr.URIs, &csr.Subject, rawSANExtension(csr.Extensions)); err != nil {
 		return false, err
 	}
 	return true, nil
@@ -92,9 +153,14 @@ func (e *NamePolicyEngine) AreCSRNamesAllowed(csr *x509.CertificateRequest) (boo
 
 // AreSANSAllowed verifies that all names in the slice of SANs are allowed.
 // The SANs are first split into DNS names, IPs, email addresses and URIs.
+// There is no subject to check against any directory name or CommonName
+// constraints, since a plain list of SANs has no notion of a subject DN. A
+// bare list of SANs also has no otherName entries to check against any
+// registered SANCheckers, since those only exist inside a parsed
+// SubjectAltName extension.
 func (e *NamePolicyEngine) AreSANsAllowed(sans []string) (bool, error) {
 	dnsNames, ips, emails, uris := x509util.SplitSANs(sans)
-	if err := e.validateNames(dnsNames, ips, emails, uris); err != nil {
+	if err := e.validateNames(dnsNames, ips, emails, uris, nil, nil); err != nil {
 		return false, err
 	}
 	return true, nil
@@ -102,7 +168,7 @@ func (e *NamePolicyEngine) AreSANsAllowed(sans []string) (bool, error) {
 
 // IsDNSAllowed verifies a single DNS domain is allowed.
 func (e *NamePolicyEngine) IsDNSAllowed(dns string) (bool, error) {
-	if err := e.validateNames([]string{dns}, []net.IP{}, []string{}, []*url.URL{}); err != nil {
+	if err := e.validateNames([]string{dns}, []net.IP{}, []string{}, []*url.URL{}, nil, nil); err != nil {
 		return false, err
 	}
 	return true, nil
@@ -110,7 +176,7 @@ func (e *NamePolicyEngine) IsDNSAllowed(dns string) (bool, error) {
 
 // IsIPAllowed verifies a single IP domain is allowed.
 func (e *NamePolicyEngine) IsIPAllowed(ip net.IP) (bool, error) {
-	if err := e.validateNames([]string{}, []net.IP{ip}, []string{}, []*url.URL{}); err != nil {
+	if err := e.validateNames([]string{}, []net.IP{ip}, []string{}, []*url.URL{}, nil, nil); err != nil {
 		return false, err
 	}
 	return true, nil
@@ -119,34 +185,108 @@ func (e *NamePolicyEngine) IsIPAllowed(ip net.IP) (bool, error) {
 // validateNames verifies that all names are allowed.
 // Its logic follows that of (a large part of) the (c *Certificate) isValid() function
 // in https://cs.opensource.google/go/go/+/refs/tags/go1.17.5:src/crypto/x509/verify.go
-func (e *NamePolicyEngine) validateNames(dnsNames []string, ips []net.IP, emailAddresses []string, uris []*url.URL) error {
+// subject is the Subject DN of the certificate or CSR being checked, validated
+// against any configured CommonName or directory name constraints; callers
+// with no meaningful subject (e.g. a bare list of SANs) pass nil, which skips
+// both of those checks entirely rather than matching them against a
+// fabricated empty name.
+// rawSAN is the raw ASN.1 value of the SubjectAltName extension, used to look
+// up otherName entries for any registered SANCheckers; callers with no
+// extension to inspect can pass nil.
+func (e *NamePolicyEngine) validateNames(dnsNames []string, ips []net.IP, emailAddresses []string, uris []*url.URL, subject *pkix.Name, rawSAN []byte) error {
 
 	// TODO: return our own type of error?
 
-	// TODO: set limit on total of all names? In x509 there's a limit on the number of comparisons
-	// that protects the CA from a DoS (i.e. many heavy comparisons). The x509 implementation takes
-	// this number as a total of all checks and keeps a (pointer to a) counter of the number of checks
-	// executed so far.
+	// numberOfComparisons tracks the total number of name-vs-constraint
+	// comparisons performed below, protecting the CA from a DoS (i.e. a CSR
+	// or certificate with many names checked against a policy with many
+	// constraints). This mirrors the limit crypto/x509 applies during chain
+	// verification; see checkNameConstraints and WithMaxComparisons.
+	numberOfComparisons := 0
+
+	// violations accumulates every CertificateInvalidError encountered when
+	// the engine is configured WithAggregateErrors; it stays nil (and unused)
+	// in the default fail-fast mode.
+	var violations []CertificateInvalidError
+
+	// record reports how a violating check should be handled: in the
+	// default fail-fast mode it is returned to the caller immediately; in
+	// aggregate mode a CertificateInvalidError is collected and checking
+	// continues, while any other kind of error (e.g. a name that can't even
+	// be parsed) still aborts immediately, since there is nothing left to
+	// check it against.
+	record := func(err error) error {
+		if err == nil {
+			return nil
+		}
+		if !e.aggregateErrors {
+			return err
+		}
+		cie, ok := err.(CertificateInvalidError)
+		if !ok {
+			return err
+		}
+		violations = append(violations, cie)
+		return nil
+	}
+
+	// otherNames holds the otherName GeneralNames out of rawSAN, parsed once
+	// up front so both the empty-SANs check below and the SANChecker pass
+	// further down can use them.
+	var otherNames []otherNameSAN
+	if len(e.customSANCheckers) > 0 && len(rawSAN) > 0 {
+		var err error
+		otherNames, err = parseOtherNameSANs(rawSAN)
+		if err != nil {
+			return err
+		}
+	}
+	hasCheckedOtherName := false
+	for _, on := range otherNames {
+		if _, ok := e.customSANCheckers[on.TypeID.String()]; ok {
+			hasCheckedOtherName = true
+			break
+		}
+	}
+
+	// Mirror crypto/x509: a certificate or CSR with no SANs of any kind is
+	// invalid when the policy enforces any DNS, IP, email or URI constraint,
+	// because there would be nothing left to check those constraints against.
+	// WithAllowEmptySANs exists for legacy callers that need to keep signing
+	// such requests. hasCheckedOtherName is included in the check so that a
+	// SAN extension containing only an otherName actually covered by a
+	// registered SANChecker (e.g. a UPN) isn't mistaken for no SANs at all;
+	// an otherName, directoryName, registeredID or other GeneralName choice
+	// that nothing checks doesn't count, since it leaves no name the policy
+	// can actually evaluate.
+	hasSANConstraints := len(e.permittedDNSDomains) > 0 || len(e.excludedDNSDomains) > 0 ||
+		len(e.permittedIPRanges) > 0 || len(e.excludedIPRanges) > 0 ||
+		len(e.permittedEmailAddresses) > 0 || len(e.excludedEmailAddresses) > 0 ||
+		len(e.permittedURIDomains) > 0 || len(e.excludedURIDomains) > 0
+	hasNoSANs := len(dnsNames) == 0 && len(ips) == 0 && len(emailAddresses) == 0 && len(uris) == 0 && !hasCheckedOtherName
+	if hasSANConstraints && hasNoSANs && !e.allowEmptySANs {
+		if err := record(CertificateInvalidError{Reason: x509.NameConstraintsWithoutSANs}); err != nil {
+			return err
+		}
+	}
 
-	// TODO: gather all errors, or return early? Currently we return early on the first wrong name; check might fail for multiple names.
-	// Perhaps make that an option?
 	for _, dns := range dnsNames {
 		if _, ok := domainToReverseLabels(dns); !ok {
 			return errors.Errorf("cannot parse dns %q", dns)
 		}
-		if err := checkNameConstraints("dns", dns, dns,
+		if err := record(checkNameConstraints("dns", dns, dns,
 			func(parsedName, constraint interface{}) (bool, error) {
 				return matchDomainConstraint(parsedName.(string), constraint.(string))
-			}, e.permittedDNSDomains, e.excludedDNSDomains); err != nil {
+			}, e.permittedDNSDomains, e.excludedDNSDomains, &numberOfComparisons, e.maxConstraintComparisons)); err != nil {
 			return err
 		}
 	}
 
 	for _, ip := range ips {
-		if err := checkNameConstraints("ip", ip.String(), ip,
+		if err := record(checkNameConstraints("ip", ip.String(), ip,
 			func(parsedName, constraint interface{}) (bool, error) {
 				return matchIPConstraint(parsedName.(net.IP), constraint.(*net.IPNet))
-			}, e.permittedIPRanges, e.excludedIPRanges); err != nil {
+			}, e.permittedIPRanges, e.excludedIPRanges, &numberOfComparisons, e.maxConstraintComparisons)); err != nil {
 			return err
 		}
 	}
@@ -156,19 +296,87 @@ func (e *NamePolicyEngine) validateNames(dnsNames []string, ips []net.IP, emailA
 		if !ok {
 			return fmt.Errorf("cannot parse rfc822Name %q", mailbox)
 		}
-		if err := checkNameConstraints("email", email, mailbox,
+		if err := record(checkNameConstraints("email", email, mailbox,
 			func(parsedName, constraint interface{}) (bool, error) {
 				return matchEmailConstraint(parsedName.(rfc2821Mailbox), constraint.(string))
-			}, e.permittedEmailAddresses, e.excludedEmailAddresses); err != nil {
+			}, e.permittedEmailAddresses, e.excludedEmailAddresses, &numberOfComparisons, e.maxConstraintComparisons)); err != nil {
 			return err
 		}
 	}
 
 	for _, uri := range uris {
-		if err := checkNameConstraints("uri", uri.String(), uri,
+		if err := record(checkNameConstraints("uri", uri.String(), uri,
 			func(parsedName, constraint interface{}) (bool, error) {
 				return matchURIConstraint(parsedName.(*url.URL), constraint.(string))
-			}, e.permittedURIDomains, e.excludedURIDomains); err != nil {
+			}, e.permittedURIDomains, e.excludedURIDomains, &numberOfComparisons, e.maxConstraintComparisons)); err != nil {
+			return err
+		}
+	}
+
+	if len(otherNames) > 0 {
+		for _, on := range otherNames {
+			checker, ok := e.customSANCheckers[on.TypeID.String()]
+			if !ok {
+				continue
+			}
+
+			numberOfComparisons++
+			if e.maxConstraintComparisons >= 0 && numberOfComparisons > e.maxConstraintComparisons {
+				if err := record(CertificateInvalidError{
+					Reason: x509.TooManyConstraints,
+					Detail: fmt.Sprintf("refusing to check otherName against more than %d constraints", e.maxConstraintComparisons),
+				}); err != nil {
+					return err
+				}
+				break
+			}
+
+			matched, err := checker.Match(on.Value.Bytes)
+			if err != nil {
+				if err := record(CertificateInvalidError{Reason: x509.CANotAuthorizedForThisName, Detail: err.Error()}); err != nil {
+					return err
+				}
+				continue
+			}
+			if !matched {
+				if err := record(CertificateInvalidError{
+					Reason: x509.CANotAuthorizedForThisName,
+					Detail: fmt.Sprintf("otherName %s is not permitted", checker.Type()),
+				}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if subject != nil && e.verifySubjectCommonName && subject.CommonName != "" {
+		cn := subject.CommonName
+		if ip := net.ParseIP(cn); ip != nil {
+			if err := record(checkNameConstraints("ip", cn, ip,
+				func(parsedName, constraint interface{}) (bool, error) {
+					return matchIPConstraint(parsedName.(net.IP), constraint.(*net.IPNet))
+				}, e.permittedIPRanges, e.excludedIPRanges, &numberOfComparisons, e.maxConstraintComparisons)); err != nil {
+				return err
+			}
+		} else {
+			if _, ok := domainToReverseLabels(cn); !ok {
+				return errors.Errorf("cannot parse dns %q", cn)
+			}
+			if err := record(checkNameConstraints("dns", cn, cn,
+				func(parsedName, constraint interface{}) (bool, error) {
+					return matchDomainConstraint(parsedName.(string), constraint.(string))
+				}, e.permittedDNSDomains, e.excludedDNSDomains, &numberOfComparisons, e.maxConstraintComparisons)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if subject != nil && (len(e.permittedDirectoryNames) > 0 || len(e.excludedDirectoryNames) > 0) {
+		subjectRDNs := subject.ToRDNSequence()
+		if err := record(checkNameConstraints("directoryName", subject.String(), subjectRDNs,
+			func(parsedName, constraint interface{}) (bool, error) {
+				return matchDirectoryNameConstraint(parsedName.(pkix.RDNSequence), constraint.(pkix.RDNSequence))
+			}, e.permittedDirectoryNames, e.excludedDirectoryNames, &numberOfComparisons, e.maxConstraintComparisons)); err != nil {
 			return err
 		}
 	}
@@ -176,8 +384,9 @@ func (e *NamePolicyEngine) validateNames(dnsNames []string, ips []net.IP, emailA
 	// TODO: when the error is not nil and returned up in the above, we can add
 	// additional context to it (i.e. the cert or csr that was inspected).
 
-	// TODO(hs): validate other types of SANs? The Go std library skips those.
-	// These could be custom checkers.
+	if len(violations) > 0 {
+		return newNamePolicyError(violations)
+	}
 
 	// if all checks out, all SANs are allowed
 	return nil
@@ -194,14 +403,18 @@ func checkNameConstraints(
 	name string,
 	parsedName interface{},
 	match func(parsedName, constraint interface{}) (match bool, err error),
-	permitted, excluded interface{}) error {
+	permitted, excluded interface{},
+	count *int, maxComparisons int) error {
 
 	excludedValue := reflect.ValueOf(excluded)
 
-	// *count += excludedValue.Len()
-	// if *count > maxConstraintComparisons {
-	// 	return x509.CertificateInvalidError{c, x509.TooManyConstraints, ""}
-	// }
+	*count += excludedValue.Len()
+	if maxComparisons >= 0 && *count > maxComparisons {
+		return CertificateInvalidError{
+			Reason: x509.TooManyConstraints,
+			Detail: fmt.Sprintf("refusing to check %s against more than %d constraints", nameType, maxComparisons),
+		}
+	}
 
 	// TODO: fix the errors; return our own, because we don't have cert ...
 
@@ -225,10 +438,13 @@ func checkNameConstraints(
 
 	permittedValue := reflect.ValueOf(permitted)
 
-	// *count += permittedValue.Len()
-	// if *count > maxConstraintComparisons {
-	// 	return x509.CertificateInvalidError{c, x509.TooManyConstraints, ""}
-	// }
+	*count += permittedValue.Len()
+	if maxComparisons >= 0 && *count > maxComparisons {
+		return CertificateInvalidError{
+			Reason: x509.TooManyConstraints,
+			Detail: fmt.Sprintf("refusing to check %s against more than %d constraints", nameType, maxComparisons),
+		}
+	}
 
 	ok := true
 	for i := 0; i < permittedValue.Len(); i++ {
@@ -484,37 +700,30 @@ func matchDomainConstraint(domain, constraint string) (bool, error) {
 	return true, nil
 }
 
-// SOURCE: https://cs.opensource.google/go/go/+/refs/tags/go1.17.5:src/crypto/x509/verify.go
+// matchIPConstraint reports whether ip is contained in constraint. Both
+// sides are normalized to the same address family before comparing: an IPv4
+// address is often represented as a 16-byte IPv4-in-IPv6 net.IP (that's what
+// net.ParseIP returns, and what crypto/x509 puts in Certificate.IPAddresses),
+// while a constraint parsed from a bare IPv4 CIDR carries a 4-byte net.IP and
+// Mask. Without normalizing both to the same length, an otherwise-matching
+// 16-byte IP and 4-byte constraint would never compare equal. ip and
+// constraint are only ever rejected as a family mismatch -- rather than
+// compared -- when one is unmistakably IPv4 and the other unmistakably IPv6.
 func matchIPConstraint(ip net.IP, constraint *net.IPNet) (bool, error) {
+	constraintIs4 := constraint.IP.To4() != nil
+	ipIs4 := ip.To4() != nil
 
-	// TODO(hs): this is code from Go library, but I got some unexpected result:
-	// with permitted net 127.0.0.0/24, 127.0.0.1 is NOT allowed. When parsing 127.0.0.1 as net.IP
-	// which is in the IPAddresses slice, the underlying length is 16. The contraint.IP has a length
-	// of 4 instead. I currently don't believe that this is a bug in Go now, but why is it like that?
-	// Is there a difference because we're not operating on a sans []string slice? Or is the Go
-	// implementation stricter regarding IPv4 vs. IPv6? I've been bitten by some unfortunate differences
-	// between the two before (i.e. IPv4 in IPv6; IP SANS in ACME)
-	// if len(ip) != len(constraint.IP) {
-	// 	return false, nil
-	// }
-
-	// for i := range ip {
-	// 	if mask := constraint.Mask[i]; ip[i]&mask != constraint.IP[i]&mask {
-	// 		return false, nil
-	// 	}
-	// }
-
-	// if isIPv4(ip) != isIPv4(constraint.IP) { // TODO(hs): this check seems to do what the above intended to do?
-	// 	return false, nil
-	// }
-
-	contained := constraint.Contains(ip) // TODO(hs): validate that this is the correct behavior.
+	if constraintIs4 != ipIs4 {
+		return false, nil
+	}
 
-	return contained, nil
-}
+	if constraintIs4 {
+		ip = ip.To4()
+	} else {
+		ip = ip.To16()
+	}
 
-func isIPv4(ip net.IP) bool {
-	return ip.To4() != nil
+	return constraint.Contains(ip), nil
 }
 
 // SOURCE: https://cs.opensource.google/go/go/+/refs/tags/go1.17.5:src/crypto/x509/verify.go
@@ -563,3 +772,233 @@ func matchURIConstraint(uri *url.URL, constraint string) (bool, error) {
 
 	return matchDomainConstraint(host, constraint)
 }
+
+// matchDirectoryNameConstraint reports whether name is within the
+// restriction defined by constraint, per RFC 5280, Section 4.2.1.10: a name
+// is within a directoryName restriction if every relative distinguished name
+// (RDN) in the constraint is also present, with an equal value, in name. The
+// restricted name is allowed to contain additional RDNs that are not part of
+// the restriction.
+func matchDirectoryNameConstraint(name, constraint pkix.RDNSequence) (bool, error) {
+outer:
+	for _, constraintRDN := range constraint {
+		for _, nameRDN := range name {
+			if rdnEqual(constraintRDN, nameRDN) {
+				continue outer
+			}
+		}
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// rdnEqual reports whether two relative distinguished names declare the same
+// attribute types with equal values, in the same order; a multi-valued RDN
+// holding the same attributes in a different order is not considered equal.
+func rdnEqual(a, b pkix.RelativeDistinguishedNameSET) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !a[i].Type.Equal(b[i].Type) {
+			return false
+		}
+		if !attributeValueEqual(a[i].Value, b[i].Value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// attributeValueEqual compares two attribute values as produced by
+// pkix.Name.ToRDNSequence(), which are almost always strings (e.g. for CN,
+// O, OU, C, L, ST). Other ASN.1 types fall back to a direct comparison.
+func attributeValueEqual(a, b interface{}) bool {
+	if as, ok := a.(string); ok {
+		bs, ok := b.(string)
+		return ok && strings.EqualFold(as, bs)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// SANChecker validates the otherName form of a SAN (RFC 5280, Section
+// 4.2.1.6), a GeneralName CHOICE that crypto/x509 itself doesn't parse.
+// Register one for a given OID with WithCustomSANChecker.
+type SANChecker interface {
+	// Type returns a short, human-readable name for the otherName kind this
+	// checker understands (e.g. "UPN"), used in CertificateInvalidError
+	// details.
+	Type() string
+	// Match reports whether raw, the otherName's ASN.1-encoded value, is
+	// permitted.
+	Match(raw []byte) (bool, error)
+}
+
+// oidExtensionSubjectAltName is the OID of the SubjectAltName extension,
+// RFC 5280, Section 4.2.1.6.
+var oidExtensionSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// rawSANExtension returns the raw ASN.1 value of the SubjectAltName
+// extension among extensions, or nil if it isn't present.
+func rawSANExtension(extensions []pkix.Extension) []byte {
+	for _, ext := range extensions {
+		if ext.Id.Equal(oidExtensionSubjectAltName) {
+			return ext.Value
+		}
+	}
+	return nil
+}
+
+// otherNameSAN is the OtherName production used by the otherName choice of
+// GeneralName:
+//
+//	OtherName ::= SEQUENCE {
+//	     type-id    OBJECT IDENTIFIER,
+//	     value      [0] EXPLICIT ANY DEFINED BY type-id }
+type otherNameSAN struct {
+	TypeID asn1.ObjectIdentifier
+	Value  asn1.RawValue `asn1:"tag:0,explicit"`
+}
+
+// otherNameGeneralNameTag is the GeneralName CHOICE tag number for
+// otherName, RFC 5280, Section 4.2.1.6.
+const otherNameGeneralNameTag = 0
+
+// parseOtherNameSANs parses the otherName entries out of rawSAN, the raw
+// ASN.1 value of a SubjectAltName extension (a SEQUENCE OF GeneralName).
+// Every other GeneralName choice is skipped, since crypto/x509 already
+// exposes those as DNSNames, IPAddresses, EmailAddresses and URIs.
+func parseOtherNameSANs(rawSAN []byte) ([]otherNameSAN, error) {
+	var seq asn1.RawValue
+	if _, err := asn1.Unmarshal(rawSAN, &seq); err != nil {
+		return nil, errors.Wrap(err, "error parsing subjectAltName extension")
+	}
+	if !seq.IsCompound || seq.Tag != asn1.TagSequence || seq.Class != asn1.ClassUniversal {
+		return nil, errors.New("error parsing subjectAltName extension: not a SEQUENCE")
+	}
+
+	var otherNames []otherNameSAN
+	rest := seq.Bytes
+	for len(rest) > 0 {
+		var generalName asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &generalName)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing generalName in subjectAltName extension")
+		}
+		if generalName.Class != asn1.ClassContextSpecific || generalName.Tag != otherNameGeneralNameTag {
+			continue
+		}
+
+		// generalName holds an IMPLICIT [0] tag around an OtherName SEQUENCE;
+		// re-tag it as a universal SEQUENCE so it can be unmarshaled into
+		// otherNameSAN below.
+		raw := append([]byte(nil), generalName.FullBytes...)
+		raw[0] = asn1.TagSequence | 0x20 // universal, constructed
+
+		var on otherNameSAN
+		if _, err := asn1.Unmarshal(raw, &on); err != nil {
+			return nil, errors.Wrap(err, "error parsing otherName in subjectAltName extension")
+		}
+		otherNames = append(otherNames, on)
+	}
+
+	return otherNames, nil
+}
+
+// domainSANChecker is a SANChecker for otherName values that embed a
+// domain-bearing string; it extracts the domain portion with extractDomain
+// and matches it against permitted and excluded domain constraints using
+// matchDomainConstraint, the same rules used for DNS SANs.
+type domainSANChecker struct {
+	typ           string
+	extractDomain func(raw []byte) (string, error)
+	permitted     []string
+	excluded      []string
+}
+
+func (c *domainSANChecker) Type() string { return c.typ }
+
+func (c *domainSANChecker) Match(raw []byte) (bool, error) {
+	domain, err := c.extractDomain(raw)
+	if err != nil {
+		return false, err
+	}
+
+	for _, excluded := range c.excluded {
+		ok, err := matchDomainConstraint(domain, excluded)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return false, nil
+		}
+	}
+
+	if len(c.permitted) == 0 {
+		return true, nil
+	}
+
+	for _, permitted := range c.permitted {
+		ok, err := matchDomainConstraint(domain, permitted)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// OIDUPN is the Microsoft User Principal Name otherName OID, as used by
+// smartcard and Kerberos logon certificates.
+var OIDUPN = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+
+// NewUPNSANChecker returns a SANChecker for the UPN otherName (OIDUPN): it
+// matches the domain portion of the UPN, i.e. everything after the last
+// "@", against permitted and excluded domain constraints the same way a DNS
+// SAN is matched. Register it with WithCustomSANChecker(x509policy.OIDUPN, ...).
+func NewUPNSANChecker(permitted, excluded []string) SANChecker {
+	return &domainSANChecker{typ: "UPN", extractDomain: upnDomain, permitted: permitted, excluded: excluded}
+}
+
+func upnDomain(raw []byte) (string, error) {
+	var upn string
+	if _, err := asn1.Unmarshal(raw, &upn); err != nil {
+		return "", errors.Wrap(err, "cannot parse UPN otherName")
+	}
+	i := strings.LastIndex(upn, "@")
+	if i == -1 {
+		return "", errors.Errorf("UPN %q has no domain part", upn)
+	}
+	return upn[i+1:], nil
+}
+
+// OIDSRVName is the SRVName otherName OID, RFC 4985.
+var OIDSRVName = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 8, 7}
+
+// NewSRVNameSANChecker returns a SANChecker for the SRVName otherName
+// (OIDSRVName): SRVName values take the form "_service._proto.domain"; once
+// the service and proto labels are stripped, the domain is matched against
+// permitted and excluded domain constraints the same way a DNS SAN is
+// matched. Register it with WithCustomSANChecker(x509policy.OIDSRVName, ...).
+func NewSRVNameSANChecker(permitted, excluded []string) SANChecker {
+	return &domainSANChecker{typ: "SRVName", extractDomain: srvNameDomain, permitted: permitted, excluded: excluded}
+}
+
+func srvNameDomain(raw []byte) (string, error) {
+	var srvName string
+	if _, err := asn1.Unmarshal(raw, &srvName); err != nil {
+		return "", errors.Wrap(err, "cannot parse SRVName otherName")
+	}
+	labels := strings.SplitN(srvName, ".", 3)
+	if len(labels) != 3 || !strings.HasPrefix(labels[0], "_") || !strings.HasPrefix(labels[1], "_") {
+		return "", errors.Errorf("cannot parse SRVName %q as _service._proto.domain", srvName)
+	}
+	return labels[2], nil
+}
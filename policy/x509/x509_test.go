@@ -0,0 +1,557 @@
+package x509policy
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func Test_checkNameConstraints_maxComparisons(t *testing.T) {
+	// Build a policy with many excluded DNS domains and a CSR with many DNS
+	// SANs, so that the number of comparisons required to check every name
+	// against every constraint exceeds the default budget of 250.
+	excluded := make([]string, 60)
+	for i := range excluded {
+		excluded[i] = "excluded-domain.com"
+	}
+
+	dnsNames := make([]string, 10)
+	for i := range dnsNames {
+		dnsNames[i] = "www.example.com"
+	}
+
+	e := &NamePolicyEngine{
+		excludedDNSDomains:       excluded,
+		maxConstraintComparisons: defaultMaxConstraintComparisons,
+	}
+
+	err := e.validateNames(dnsNames, []net.IP{}, []string{}, nil, nil, nil)
+	if err == nil {
+		t.Fatal("validateNames() expected error, got nil")
+	}
+
+	cie, ok := err.(CertificateInvalidError)
+	if !ok {
+		t.Fatalf("validateNames() error type = %T, want CertificateInvalidError", err)
+	}
+	if cie.Reason != x509.TooManyConstraints {
+		t.Errorf("validateNames() reason = %v, want %v", cie.Reason, x509.TooManyConstraints)
+	}
+	if !strings.Contains(cie.Error(), "too many comparisons") {
+		t.Errorf("Error() = %q, want it to mention too many comparisons", cie.Error())
+	}
+}
+
+func Test_checkNameConstraints_maxComparisonsOption(t *testing.T) {
+	e, err := New(WithMaxComparisons(1))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	e.permittedDNSDomains = []string{"example.com", "example.org"}
+
+	err = e.validateNames([]string{"www.example.com"}, []net.IP{}, []string{}, nil, nil, nil)
+	if err == nil {
+		t.Fatal("validateNames() expected error, got nil")
+	}
+
+	cie, ok := err.(CertificateInvalidError)
+	if !ok {
+		t.Fatalf("validateNames() error type = %T, want CertificateInvalidError", err)
+	}
+	if cie.Reason != x509.TooManyConstraints {
+		t.Errorf("validateNames() reason = %v, want %v", cie.Reason, x509.TooManyConstraints)
+	}
+}
+
+func Test_validateNames_aggregateErrors(t *testing.T) {
+	e, err := New(WithAggregateErrors())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	e.permittedDNSDomains = []string{"example.com"}
+	e.permittedIPRanges = []*net.IPNet{{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(32, 32)}}
+
+	err = e.validateNames(
+		[]string{"www.example.com", "evil.com"},
+		[]net.IP{net.ParseIP("8.8.8.8")},
+		[]string{}, nil, nil, nil,
+	)
+	if err == nil {
+		t.Fatal("validateNames() expected error, got nil")
+	}
+
+	npe, ok := err.(*NamePolicyError)
+	if !ok {
+		t.Fatalf("validateNames() error type = %T, want *NamePolicyError", err)
+	}
+
+	violations := npe.Violations()
+	if len(violations) != 2 {
+		t.Fatalf("Violations() = %d, want 2", len(violations))
+	}
+	if violations[0].Reason != x509.CANotAuthorizedForThisName || !strings.Contains(violations[0].Detail, "evil.com") {
+		t.Errorf("Violations()[0] = %+v, want it to mention evil.com", violations[0])
+	}
+	if violations[1].Reason != x509.CANotAuthorizedForThisName || !strings.Contains(violations[1].Detail, "8.8.8.8") {
+		t.Errorf("Violations()[1] = %+v, want it to mention 8.8.8.8", violations[1])
+	}
+	if !errors.Is(err, violations[0]) {
+		t.Error("errors.Is(err, violations[0]) = false, want true")
+	}
+}
+
+func Test_matchIPConstraint(t *testing.T) {
+	mustCIDR := func(cidr string) *net.IPNet {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("net.ParseCIDR(%q) error = %v", cidr, err)
+		}
+		return ipNet
+	}
+
+	tests := []struct {
+		name       string
+		ip         net.IP
+		constraint *net.IPNet
+		want       bool
+	}{
+		{"ipv4 in range", net.ParseIP("127.0.0.1"), mustCIDR("127.0.0.0/24"), true},
+		{"ipv4 out of range", net.ParseIP("127.0.1.1"), mustCIDR("127.0.0.0/24"), false},
+		{"ipv4-mapped ipv6 literal in ipv4 range", net.ParseIP("::ffff:127.0.0.1"), mustCIDR("127.0.0.0/24"), true},
+		{"4-byte ip in range", net.IPv4(127, 0, 0, 1).To4(), mustCIDR("127.0.0.0/24"), true},
+		{"pure ipv6 in range", net.ParseIP("fd00::1"), mustCIDR("fd00::/8"), true},
+		{"pure ipv6 out of range", net.ParseIP("2001:db8::1"), mustCIDR("fd00::/8"), false},
+		{"ipv4 against ipv6 constraint is a family mismatch", net.ParseIP("127.0.0.1"), mustCIDR("fd00::/8"), false},
+		{"pure ipv6 against ipv4 constraint is a family mismatch", net.ParseIP("2001:db8::1"), mustCIDR("127.0.0.0/24"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := matchIPConstraint(tt.ip, tt.constraint)
+			if err != nil {
+				t.Fatalf("matchIPConstraint() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("matchIPConstraint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateNames_dualFamilyCIDR(t *testing.T) {
+	e, err := New(WithPermittedCIDR("10.0.0.0/8"), WithPermittedCIDR("fd00::/8"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if err := e.validateNames(nil, []net.IP{net.ParseIP("10.1.2.3")}, nil, nil, nil, nil); err != nil {
+		t.Errorf("validateNames() error = %v, want nil for permitted IPv4", err)
+	}
+	if err := e.validateNames(nil, []net.IP{net.ParseIP("fd00::1")}, nil, nil, nil, nil); err != nil {
+		t.Errorf("validateNames() error = %v, want nil for permitted IPv6", err)
+	}
+	if err := e.validateNames(nil, []net.IP{net.ParseIP("192.168.0.1")}, nil, nil, nil, nil); err == nil {
+		t.Error("validateNames() expected error for IPv4 outside both ranges, got nil")
+	}
+}
+
+func Test_WithPermittedCIDR_invalid(t *testing.T) {
+	if _, err := New(WithPermittedCIDR("not-a-cidr")); err == nil {
+		t.Error("New(WithPermittedCIDR(...)) expected error for invalid CIDR, got nil")
+	}
+}
+
+func Test_validateNames_excludedCIDR(t *testing.T) {
+	e, err := New(WithExcludedCIDR("10.0.0.0/8"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if err := e.validateNames(nil, []net.IP{net.ParseIP("192.168.0.1")}, nil, nil, nil, nil); err != nil {
+		t.Errorf("validateNames() error = %v, want nil for an IP outside the excluded range", err)
+	}
+
+	err = e.validateNames(nil, []net.IP{net.ParseIP("10.1.2.3")}, nil, nil, nil, nil)
+	if err == nil {
+		t.Fatal("validateNames() expected error for an IP inside the excluded range, got nil")
+	}
+	if cie, ok := err.(CertificateInvalidError); !ok || cie.Reason != x509.CANotAuthorizedForThisName {
+		t.Errorf("validateNames() error = %v, want a CertificateInvalidError with reason CANotAuthorizedForThisName", err)
+	}
+}
+
+// buildOtherNameSANExtension builds the raw ASN.1 value of a SubjectAltName
+// extension (SEQUENCE OF GeneralName) containing a single otherName entry
+// for oid with the given UTF8String value.
+func buildOtherNameSANExtension(t *testing.T, oid asn1.ObjectIdentifier, value string) []byte {
+	t.Helper()
+
+	valueTLV, err := asn1.Marshal(value)
+	if err != nil {
+		t.Fatalf("asn1.Marshal(value) error = %v", err)
+	}
+
+	explicitValue, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: valueTLV})
+	if err != nil {
+		t.Fatalf("asn1.Marshal(explicitValue) error = %v", err)
+	}
+
+	otherName, err := asn1.Marshal(otherNameSAN{TypeID: oid, Value: asn1.RawValue{FullBytes: explicitValue}})
+	if err != nil {
+		t.Fatalf("asn1.Marshal(otherNameSAN) error = %v", err)
+	}
+	// otherName is currently tagged as a universal SEQUENCE; re-tag it as
+	// GeneralName's otherName choice, an IMPLICIT [0] around that SEQUENCE.
+	otherName[0] = asn1.ClassContextSpecific<<6 | 0x20 | otherNameGeneralNameTag
+
+	generalNames, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: otherName})
+	if err != nil {
+		t.Fatalf("asn1.Marshal(generalNames) error = %v", err)
+	}
+
+	return generalNames
+}
+
+func Test_validateNames_customSANChecker(t *testing.T) {
+	e, err := New(WithCustomSANChecker(OIDUPN, NewUPNSANChecker([]string{"corp.example.com"}, nil)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	allowed := buildOtherNameSANExtension(t, OIDUPN, "jdoe@corp.example.com")
+	if err := e.validateNames(nil, nil, nil, nil, nil, allowed); err != nil {
+		t.Errorf("validateNames() error = %v, want nil for a permitted UPN", err)
+	}
+
+	denied := buildOtherNameSANExtension(t, OIDUPN, "jdoe@evil.example.org")
+	err = e.validateNames(nil, nil, nil, nil, nil, denied)
+	if err == nil {
+		t.Fatal("validateNames() expected error for a UPN outside the permitted domain, got nil")
+	}
+	cie, ok := err.(CertificateInvalidError)
+	if !ok {
+		t.Fatalf("validateNames() error type = %T, want CertificateInvalidError", err)
+	}
+	if !strings.Contains(cie.Error(), "UPN") {
+		t.Errorf("Error() = %q, want it to mention UPN", cie.Error())
+	}
+
+	// An otherName OID with no registered checker is ignored.
+	e2, err := New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := e2.validateNames(nil, nil, nil, nil, nil, denied); err != nil {
+		t.Errorf("validateNames() error = %v, want nil without a registered SANChecker", err)
+	}
+}
+
+func Test_validateNames_excludedSRVNameSANChecker(t *testing.T) {
+	e, err := New(WithCustomSANChecker(OIDSRVName, NewSRVNameSANChecker(nil, []string{"evil.example.org"})))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	allowed := buildOtherNameSANExtension(t, OIDSRVName, "_sip._tcp.example.com")
+	if err := e.validateNames(nil, nil, nil, nil, nil, allowed); err != nil {
+		t.Errorf("validateNames() error = %v, want nil for a SRVName outside the excluded domain", err)
+	}
+
+	denied := buildOtherNameSANExtension(t, OIDSRVName, "_sip._tcp.evil.example.org")
+	err = e.validateNames(nil, nil, nil, nil, nil, denied)
+	if err == nil {
+		t.Fatal("validateNames() expected error for a SRVName within the excluded domain, got nil")
+	}
+	cie, ok := err.(CertificateInvalidError)
+	if !ok {
+		t.Fatalf("validateNames() error type = %T, want CertificateInvalidError", err)
+	}
+	if !strings.Contains(cie.Error(), "SRVName") {
+		t.Errorf("Error() = %q, want it to mention SRVName", cie.Error())
+	}
+}
+
+func Test_SRVNameSANChecker(t *testing.T) {
+	checker := NewSRVNameSANChecker([]string{"example.com"}, nil)
+	if checker.Type() != "SRVName" {
+		t.Errorf("Type() = %q, want %q", checker.Type(), "SRVName")
+	}
+
+	srvName, err := asn1.Marshal("_sip._tcp.example.com")
+	if err != nil {
+		t.Fatalf("asn1.Marshal() error = %v", err)
+	}
+	ok, err := checker.Match(srvName)
+	if err != nil {
+		t.Fatalf("Match() error = %v", err)
+	}
+	if !ok {
+		t.Error("Match() = false, want true for a permitted SRVName")
+	}
+
+	outside, err := asn1.Marshal("_sip._tcp.evil.example.org")
+	if err != nil {
+		t.Fatalf("asn1.Marshal() error = %v", err)
+	}
+	ok, err = checker.Match(outside)
+	if err != nil {
+		t.Fatalf("Match() error = %v", err)
+	}
+	if ok {
+		t.Error("Match() = true, want false for a SRVName outside the permitted domain")
+	}
+}
+
+func Test_validateNames_otherNameOnlySAN(t *testing.T) {
+	e, err := New(WithCustomSANChecker(OIDUPN, NewUPNSANChecker([]string{"corp.example.com"}, nil)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	e.permittedDNSDomains = []string{"example.com"}
+
+	rawSAN := buildOtherNameSANExtension(t, OIDUPN, "jdoe@corp.example.com")
+	if err := e.validateNames(nil, nil, nil, nil, nil, rawSAN); err != nil {
+		t.Errorf("validateNames() error = %v, want nil for a certificate whose only SAN is a permitted UPN", err)
+	}
+}
+
+func Test_matchDirectoryNameConstraint(t *testing.T) {
+	tests := []struct {
+		name       string
+		dn         pkix.Name
+		constraint pkix.Name
+		want       bool
+	}{
+		{
+			name:       "exact match",
+			dn:         pkix.Name{Country: []string{"US"}, Organization: []string{"Acme Co"}, CommonName: "www.example.com"},
+			constraint: pkix.Name{Country: []string{"US"}, Organization: []string{"Acme Co"}, CommonName: "www.example.com"},
+			want:       true,
+		},
+		{
+			name:       "name has additional RDNs beyond the constraint",
+			dn:         pkix.Name{Country: []string{"US"}, Organization: []string{"Acme Co"}, OrganizationalUnit: []string{"Engineering"}, CommonName: "www.example.com"},
+			constraint: pkix.Name{Country: []string{"US"}, Organization: []string{"Acme Co"}},
+			want:       true,
+		},
+		{
+			name:       "constraint requires an RDN the name doesn't have",
+			dn:         pkix.Name{Country: []string{"US"}, Organization: []string{"Acme Co"}},
+			constraint: pkix.Name{Country: []string{"US"}, Organization: []string{"Acme Co"}, OrganizationalUnit: []string{"Engineering"}},
+			want:       false,
+		},
+		{
+			name:       "attribute value mismatch",
+			dn:         pkix.Name{Country: []string{"US"}, Organization: []string{"Evil Co"}},
+			constraint: pkix.Name{Country: []string{"US"}, Organization: []string{"Acme Co"}},
+			want:       false,
+		},
+		{
+			name:       "attribute matching is case-insensitive",
+			dn:         pkix.Name{Organization: []string{"ACME CO"}},
+			constraint: pkix.Name{Organization: []string{"acme co"}},
+			want:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := matchDirectoryNameConstraint(tt.dn.ToRDNSequence(), tt.constraint.ToRDNSequence())
+			if err != nil {
+				t.Fatalf("matchDirectoryNameConstraint() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("matchDirectoryNameConstraint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_rdnEqual(t *testing.T) {
+	// A multi-valued RDN packs more than one AttributeTypeAndValue into a
+	// single pkix.RelativeDistinguishedNameSET; rdnEqual compares those
+	// pairwise by position, so the same attributes in a different order are
+	// not considered equal even though, as a set, they're the same.
+	cn := pkix.AttributeTypeAndValue{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: "www.example.com"}
+	ou := pkix.AttributeTypeAndValue{Type: asn1.ObjectIdentifier{2, 5, 4, 11}, Value: "Engineering"}
+
+	multiValued := pkix.RelativeDistinguishedNameSET{cn, ou}
+	sameOrder := pkix.RelativeDistinguishedNameSET{cn, ou}
+	reordered := pkix.RelativeDistinguishedNameSET{ou, cn}
+	differentLength := pkix.RelativeDistinguishedNameSET{cn}
+
+	if !rdnEqual(multiValued, sameOrder) {
+		t.Error("rdnEqual() = false, want true for identical multi-valued RDNs in the same order")
+	}
+	if rdnEqual(multiValued, reordered) {
+		t.Error("rdnEqual() = true, want false for a multi-valued RDN compared against itself reordered")
+	}
+	if rdnEqual(multiValued, differentLength) {
+		t.Error("rdnEqual() = true, want false when the two RDNs declare a different number of attributes")
+	}
+}
+
+func Test_validateNames_directoryNameConstraint(t *testing.T) {
+	e, err := New(WithPermittedDirectoryName(pkix.Name{Country: []string{"US"}, Organization: []string{"Acme Co"}}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	allowed := &x509.Certificate{
+		Subject:  pkix.Name{Country: []string{"US"}, Organization: []string{"Acme Co"}, CommonName: "www.example.com"},
+		DNSNames: []string{"www.example.com"},
+	}
+	if ok, err := e.AreCertificateNamesAllowed(allowed); !ok || err != nil {
+		t.Errorf("AreCertificateNamesAllowed() = (%v, %v), want (true, nil) for a subject within the permitted directory name", ok, err)
+	}
+
+	denied := &x509.Certificate{
+		Subject:  pkix.Name{Country: []string{"US"}, Organization: []string{"Evil Co"}, CommonName: "www.example.com"},
+		DNSNames: []string{"www.example.com"},
+	}
+	ok, err := e.AreCertificateNamesAllowed(denied)
+	if ok || err == nil {
+		t.Fatalf("AreCertificateNamesAllowed() = (%v, %v), want (false, non-nil) for a subject outside the permitted directory name", ok, err)
+	}
+	cie, isCIE := err.(CertificateInvalidError)
+	if !isCIE {
+		t.Fatalf("AreCertificateNamesAllowed() error type = %T, want CertificateInvalidError", err)
+	}
+	if cie.Reason != x509.CANotAuthorizedForThisName {
+		t.Errorf("AreCertificateNamesAllowed() reason = %v, want %v", cie.Reason, x509.CANotAuthorizedForThisName)
+	}
+}
+
+func Test_validateNames_excludedDirectoryNameConstraint(t *testing.T) {
+	e, err := New(WithExcludedDirectoryName(pkix.Name{Country: []string{"US"}, Organization: []string{"Evil Co"}}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	allowed := &x509.Certificate{
+		Subject:  pkix.Name{Country: []string{"US"}, Organization: []string{"Acme Co"}, CommonName: "www.example.com"},
+		DNSNames: []string{"www.example.com"},
+	}
+	if ok, err := e.AreCertificateNamesAllowed(allowed); !ok || err != nil {
+		t.Errorf("AreCertificateNamesAllowed() = (%v, %v), want (true, nil) for a subject outside the excluded directory name", ok, err)
+	}
+
+	denied := &x509.Certificate{
+		Subject:  pkix.Name{Country: []string{"US"}, Organization: []string{"Evil Co"}, CommonName: "www.example.com"},
+		DNSNames: []string{"www.example.com"},
+	}
+	ok, err := e.AreCertificateNamesAllowed(denied)
+	if ok || err == nil {
+		t.Fatalf("AreCertificateNamesAllowed() = (%v, %v), want (false, non-nil) for a subject within the excluded directory name", ok, err)
+	}
+	cie, isCIE := err.(CertificateInvalidError)
+	if !isCIE {
+		t.Fatalf("AreCertificateNamesAllowed() error type = %T, want CertificateInvalidError", err)
+	}
+	if cie.Reason != x509.CANotAuthorizedForThisName {
+		t.Errorf("AreCertificateNamesAllowed() reason = %v, want %v", cie.Reason, x509.CANotAuthorizedForThisName)
+	}
+}
+
+func Test_validateNames_noSubjectSkipsDirectoryNameConstraint(t *testing.T) {
+	// AreSANsAllowed, IsDNSAllowed and IsIPAllowed have no subject DN to
+	// check against a directoryName constraint, since a bare SAN (or list of
+	// SANs) carries no notion of a Subject DN. Configuring one must not
+	// cause every such call to fail, as it would if it were checked against
+	// a fabricated empty pkix.Name{}.
+	e, err := New(WithPermittedDirectoryName(pkix.Name{Country: []string{"US"}, Organization: []string{"Acme Co"}}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if ok, err := e.AreSANsAllowed([]string{"www.example.com"}); !ok || err != nil {
+		t.Errorf("AreSANsAllowed() = (%v, %v), want (true, nil) with no subject to check", ok, err)
+	}
+	if ok, err := e.IsDNSAllowed("www.example.com"); !ok || err != nil {
+		t.Errorf("IsDNSAllowed() = (%v, %v), want (true, nil) with no subject to check", ok, err)
+	}
+	if ok, err := e.IsIPAllowed(net.ParseIP("127.0.0.1")); !ok || err != nil {
+		t.Errorf("IsIPAllowed() = (%v, %v), want (true, nil) with no subject to check", ok, err)
+	}
+}
+
+func Test_validateNames_subjectCommonNameValidation(t *testing.T) {
+	e, err := New(WithSubjectCommonNameValidation())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	e.permittedDNSDomains = []string{"example.com"}
+	e.permittedIPRanges = []*net.IPNet{{IP: net.ParseIP("10.0.0.0"), Mask: net.CIDRMask(8, 32)}}
+
+	dnsSubject := pkix.Name{CommonName: "www.example.com"}
+	if err := e.validateNames([]string{"www.example.com"}, nil, nil, nil, &dnsSubject, nil); err != nil {
+		t.Errorf("validateNames() error = %v, want nil for a CommonName within the permitted DNS domain", err)
+	}
+
+	ipSubject := pkix.Name{CommonName: "10.1.2.3"}
+	if err := e.validateNames([]string{"www.example.com"}, nil, nil, nil, &ipSubject, nil); err != nil {
+		t.Errorf("validateNames() error = %v, want nil for a CommonName within the permitted IP range", err)
+	}
+
+	badSubject := pkix.Name{CommonName: "www.evil.com"}
+	err = e.validateNames([]string{"www.example.com"}, nil, nil, nil, &badSubject, nil)
+	if err == nil {
+		t.Fatal("validateNames() expected error for a CommonName outside the permitted DNS domain, got nil")
+	}
+	if cie, ok := err.(CertificateInvalidError); !ok || cie.Reason != x509.CANotAuthorizedForThisName {
+		t.Errorf("validateNames() error = %v, want a CertificateInvalidError with reason CANotAuthorizedForThisName", err)
+	}
+}
+
+func Test_validateNames_emptySANsRejected(t *testing.T) {
+	e, err := New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	e.permittedDNSDomains = []string{"example.com"}
+
+	err = e.validateNames(nil, nil, nil, nil, nil, nil)
+	if err == nil {
+		t.Fatal("validateNames() expected error for zero SANs with DNS constraints configured, got nil")
+	}
+	if cie, ok := err.(CertificateInvalidError); !ok || cie.Reason != x509.NameConstraintsWithoutSANs {
+		t.Errorf("validateNames() error = %v, want a CertificateInvalidError with reason NameConstraintsWithoutSANs", err)
+	}
+}
+
+func Test_validateNames_unrecognizedOtherNameIsTreatedAsNoSANs(t *testing.T) {
+	// A SAN extension whose only entry is an otherName with no registered
+	// SANChecker leaves nothing a DNS policy can actually evaluate, so it
+	// must still trip NameConstraintsWithoutSANs rather than being let
+	// through just because rawSAN is non-empty.
+	e, err := New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	e.permittedDNSDomains = []string{"example.com"}
+
+	rawSAN := buildOtherNameSANExtension(t, OIDUPN, "jdoe@corp.example.com")
+	err = e.validateNames(nil, nil, nil, nil, nil, rawSAN)
+	if err == nil {
+		t.Fatal("validateNames() expected error for an otherName with no registered SANChecker, got nil")
+	}
+	if cie, ok := err.(CertificateInvalidError); !ok || cie.Reason != x509.NameConstraintsWithoutSANs {
+		t.Errorf("validateNames() error = %v, want a CertificateInvalidError with reason NameConstraintsWithoutSANs", err)
+	}
+}
+
+func Test_validateNames_allowEmptySANs(t *testing.T) {
+	e, err := New(WithAllowEmptySANs())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	e.permittedDNSDomains = []string{"example.com"}
+
+	if err := e.validateNames(nil, nil, nil, nil, nil, nil); err != nil {
+		t.Errorf("validateNames() error = %v, want nil for zero SANs once WithAllowEmptySANs is set", err)
+	}
+}